@@ -0,0 +1,258 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// DeflateWriter is the block-parallel DEFLATE engine behind this
+// package's gzip Writer, exposed on its own for callers that need
+// parallel compression inside a container they frame themselves --
+// archive/zip entries being the motivating case -- without paying for a
+// gzip header and trailer they don't want. Like Writer, it splits the
+// input into blocks and compresses them concurrently, emitting a sync
+// flush between blocks so the output stays a single valid, streamable
+// DEFLATE stream; unlike Writer it does not write a gzip Header or a
+// CRC32/ISIZE trailer, and the final block is closed normally (not sync
+// flushed) so the stream ends cleanly.
+type DeflateWriter struct {
+	w         io.Writer
+	level     int
+	blockSize int
+	workers   int
+
+	digest hash.Hash32
+	size   int64
+
+	buf  []byte
+	dict []byte
+
+	sem   chan struct{}
+	order chan chan deflateResult
+	done  chan error
+	err   error
+
+	// onBlock, if set, is called from writeLoop immediately before each
+	// block's compressed bytes are written to w, with the uncompressed
+	// offset of the block (relative to the first byte written to this
+	// DeflateWriter) and the dictionary it was compressed against. Writer
+	// uses it to record a block index as it writes. It is always called
+	// from writeLoop's single goroutine, never concurrently with itself.
+	onBlock func(uncompressedOffset int64, dict []byte)
+}
+
+type deflateResult struct {
+	buf             []byte
+	err             error
+	notify          chan error
+	dict            []byte
+	uncompressedLen int
+}
+
+// NewDeflateWriter returns a DeflateWriter writing compressed data to w
+// at the given compression level. blockSize and workers behave as
+// Writer.SetConcurrency's parameters: blockSize is the approximate
+// amount of uncompressed data per block, and workers bounds how many
+// blocks may be compressed at once.
+func NewDeflateWriter(w io.Writer, level, blockSize, workers int) *DeflateWriter {
+	if blockSize <= 512 {
+		blockSize = defaultBlockSize
+	}
+	if workers <= 0 {
+		workers = defaultBlocks
+	}
+	d := &DeflateWriter{
+		w:         w,
+		level:     level,
+		blockSize: blockSize,
+		workers:   workers,
+		digest:    crc32.NewIEEE(),
+		sem:       make(chan struct{}, workers),
+		order:     make(chan chan deflateResult, workers),
+		done:      make(chan error, 1),
+	}
+	go d.writeLoop()
+	return d
+}
+
+// SetConcurrency adjusts the block size and worker count. It must be
+// called before the first call to Write.
+func (d *DeflateWriter) SetConcurrency(blockSize, workers int) {
+	if blockSize > 0 {
+		d.blockSize = blockSize
+	}
+	if workers > 0 {
+		d.workers = workers
+		d.sem = make(chan struct{}, workers)
+	}
+}
+
+// writeLoop drains compressed blocks in submission order and copies
+// them to the underlying writer, so concurrent compression doesn't
+// reorder the stream.
+func (d *DeflateWriter) writeLoop() {
+	var uncompressedOffset int64
+	for res := range d.order {
+		r := <-res
+		if r.err != nil {
+			if r.notify != nil {
+				r.notify <- r.err
+			}
+			d.done <- r.err
+			return
+		}
+		if d.onBlock != nil {
+			d.onBlock(uncompressedOffset, r.dict)
+		}
+		_, err := d.w.Write(r.buf)
+		uncompressedOffset += int64(r.uncompressedLen)
+		if r.notify != nil {
+			r.notify <- err
+		}
+		if err != nil {
+			d.done <- err
+			return
+		}
+	}
+	d.done <- nil
+}
+
+// compressBlock hands block off to a (bounded) goroutine that compresses
+// it against dict, flushing with a sync marker unless last is set, and
+// returns the channel writeLoop will read the result from. dict is the
+// last dictWindow bytes of the previous block (nil for the first one),
+// primed the same way the gzip Writer primes its own blocks, so matches
+// can still be found across the sync-flush boundary instead of starting
+// every block with an empty window. If notify is non-nil, writeLoop
+// sends this block's write error (nil on success) once its bytes have
+// reached w, so Flush can wait for its own block specifically instead
+// of only the final one Close waits for. writeLoop also reports dict
+// and the block's uncompressed length to onBlock, if set.
+func (d *DeflateWriter) compressBlock(block, dict []byte, last bool, notify chan error) {
+	res := make(chan deflateResult, 1)
+	d.order <- res
+
+	d.sem <- struct{}{}
+	go func() {
+		defer func() { <-d.sem }()
+		var buf bytes.Buffer
+		fw, err := flate.NewWriterDict(&buf, d.level, dict)
+		if err == nil {
+			_, err = fw.Write(block)
+		}
+		if err == nil {
+			if last {
+				err = fw.Close()
+			} else {
+				err = fw.Flush()
+			}
+		}
+		res <- deflateResult{buf: buf.Bytes(), err: err, notify: notify, dict: dict, uncompressedLen: len(block)}
+	}()
+}
+
+// Write implements io.Writer. Complete blocks of blockSize are handed
+// off for compression as they fill; the remainder is buffered until the
+// next Write or Close.
+func (d *DeflateWriter) Write(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n := len(p)
+	d.digest.Write(p)
+	d.size += int64(len(p))
+	d.buf = append(d.buf, p...)
+	for len(d.buf) >= d.blockSize {
+		block := d.buf[:d.blockSize]
+		d.buf = append([]byte(nil), d.buf[d.blockSize:]...)
+		d.compressBlock(block, d.dict, false, nil)
+		d.dict = dictTail(block)
+	}
+	return n, nil
+}
+
+// Flush compresses any buffered data as a new sync-flushed block and
+// waits for it to reach w, without closing the stream. Unlike Close, a
+// DeflateWriter can still be written to afterwards.
+func (d *DeflateWriter) Flush() error {
+	if d.err != nil {
+		return d.err
+	}
+	notify := make(chan error, 1)
+	d.compressBlock(d.buf, d.dict, false, notify)
+	d.dict = dictTail(d.buf)
+	d.buf = nil
+	if err := <-notify; err != nil {
+		d.err = err
+	}
+	return d.err
+}
+
+// CRC32 returns the running CRC32 of all data written so far.
+func (d *DeflateWriter) CRC32() uint32 {
+	return d.digest.Sum32()
+}
+
+// UncompressedSize returns the number of bytes written so far.
+func (d *DeflateWriter) UncompressedSize() int {
+	return int(d.size)
+}
+
+// Close flushes any buffered data as a final, non-sync-flushed block and
+// waits for all outstanding blocks to be written out in order. It does
+// not close the underlying io.Writer.
+func (d *DeflateWriter) Close() error {
+	if d.err != nil {
+		return d.err
+	}
+	d.compressBlock(d.buf, d.dict, true, nil)
+	d.buf = nil
+	close(d.order)
+	d.err = <-d.done
+	return d.err
+}
+
+// ZipMethod is a zip method ID reserved for DeflateWriter. archive/zip
+// already registers its own compressor for zip.Deflate in its init, and
+// zip.RegisterCompressor panics if a method is registered twice, so
+// NewZipCompressor's output must be registered under a method ID of its
+// own rather than zip.Deflate.
+const ZipMethod = 0x0099
+
+// NewZipCompressor returns a zip.RegisterCompressor-compatible function
+// that compresses each entry with a DeflateWriter, e.g.:
+//
+//	zip.RegisterCompressor(pgzip.ZipMethod, pgzip.NewZipCompressor(6, 250000, 4))
+//
+// Entries written with it must be created with zip.Writer.CreateHeader
+// and a Method of pgzip.ZipMethod, since zip.Writer.Create always uses
+// zip.Deflate. Register NewZipDecompressor under the same method ID so
+// archive/zip can read the entries back; it only has built-in
+// decompressors for Store and Deflate.
+func NewZipCompressor(level, blockSize, workers int) func(io.Writer) (io.WriteCloser, error) {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return NewDeflateWriter(w, level, blockSize, workers), nil
+	}
+}
+
+// NewZipDecompressor returns a zip.RegisterDecompressor-compatible
+// function for entries written by NewZipCompressor, e.g.:
+//
+//	zip.RegisterDecompressor(pgzip.ZipMethod, pgzip.NewZipDecompressor())
+//
+// DeflateWriter's block-parallel compression only changes how an
+// entry's bytes are produced, not the DEFLATE stream format itself, so
+// reading one back needs nothing beyond the standard library's own
+// flate.Reader.
+func NewZipDecompressor() func(io.Reader) io.ReadCloser {
+	return func(r io.Reader) io.ReadCloser {
+		return flate.NewReader(r)
+	}
+}
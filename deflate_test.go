@@ -0,0 +1,93 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"testing"
+)
+
+// testPayload returns a deterministic, repetitive byte slice of
+// reasonable size for round-trip tests, generated inline rather than
+// read from a fixture.
+func testPayload() []byte {
+	return bytes.Repeat([]byte(`{"hello":"world","values":[1,2,3,4,5]}`+"\n"), 500)
+}
+
+// TestDeflateWriterRoundTrip compresses with DeflateWriter directly and
+// decodes with compress/flate, independent of any container format.
+func TestDeflateWriterRoundTrip(t *testing.T) {
+	payload := testPayload()
+
+	var buf bytes.Buffer
+	w := NewDeflateWriter(&buf, 6, 4096, 4)
+	if _, err := w.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if w.UncompressedSize() != len(payload) {
+		t.Fatalf("UncompressedSize() = %d, want %d", w.UncompressedSize(), len(payload))
+	}
+
+	fr := flate.NewReader(&buf)
+	decoded, err := ioutil.ReadAll(fr)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Fatal("decoded content does not match original")
+	}
+}
+
+// TestZipCompressor wraps DeflateWriter in archive/zip via
+// NewZipCompressor and checks the entry round-trips through zip's own
+// reader.
+func TestZipCompressor(t *testing.T) {
+	zip.RegisterCompressor(ZipMethod, NewZipCompressor(6, 4096, 4))
+	zip.RegisterDecompressor(ZipMethod, NewZipDecompressor())
+
+	payload := testPayload()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	fw, err := zw.CreateHeader(&zip.FileHeader{
+		Name:   "test.json",
+		Method: ZipMethod,
+	})
+	if err != nil {
+		t.Fatalf("CreateHeader: %v", err)
+	}
+	if _, err := fw.Write(payload); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("zip.Writer.Close: %v", err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("zip.NewReader: %v", err)
+	}
+	if len(zr.File) != 1 {
+		t.Fatalf("got %d files, want 1", len(zr.File))
+	}
+	rc, err := zr.File[0].Open()
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	decoded, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(decoded, payload) {
+		t.Fatal("decoded content does not match original")
+	}
+}
@@ -11,6 +11,14 @@
 // The gzip decompression has not been modified, but remains in the package,
 // so you can use it as a complete replacement for "compress/gzip".
 //
+// Reader.SetConcurrency lets a stream written with sync-flush-delimited
+// blocks (such as this package's own Writer produces) be read back
+// through the same readahead pipeline as the block-parallel writer, but
+// it does not decode blocks concurrently: each block's DEFLATE
+// back-references can reach into the previous one, so decoding one
+// block still has to wait on the one before it. It is not a parallel
+// decompressor.
+//
 // See more at https://github.com/klauspost/pgzip
 package pgzip
 
@@ -91,6 +99,10 @@ type Reader struct {
 	lastBlock   bool
 	blockSize   int
 	blocks      int
+	concurrent  bool
+
+	multistream bool
+	atEOF       bool // true once the current member's checksum has been verified and Multistream(false) is in effect
 }
 
 // NewReader creates a new Reader reading the given reader.
@@ -100,6 +112,7 @@ func NewReader(r io.Reader) (*Reader, error) {
 	z := new(Reader)
 	z.blocks = defaultBlocks
 	z.blockSize = defaultBlockSize
+	z.multistream = true
 	z.r = makeReader(r)
 	z.digest = crc32.NewIEEE()
 	if err := z.readHeader(true); err != nil {
@@ -122,6 +135,7 @@ func NewReaderN(r io.Reader, blockSize, blocks int) (*Reader, error) {
 	z := new(Reader)
 	z.blocks = blocks
 	z.blockSize = blockSize
+	z.multistream = true
 	z.r = makeReader(r)
 	z.digest = crc32.NewIEEE()
 	if err := z.readHeader(true); err != nil {
@@ -142,6 +156,38 @@ func (z *Reader) Reset(r io.Reader) error {
 	z.digest = crc32.NewIEEE()
 	z.size = 0
 	z.err = nil
+	z.atEOF = false
+	return z.readHeader(true)
+}
+
+// Multistream controls whether the Reader supports multistream files.
+//
+// If enabled (the default), the Reader expects the input to be a
+// sequence of individually gzipped members, each with its own header
+// and trailer, and Read transparently concatenates their uncompressed
+// data as if it were one stream.
+//
+// Calling Multistream(false) disables this: Read instead returns
+// io.EOF as soon as it reaches the end of the current member, and the
+// caller must call NextMember to advance to the following one (if any)
+// before reading further. This is useful for formats that pack several
+// logical files into one gzip stream and need to inspect each member's
+// Header in turn.
+func (z *Reader) Multistream(ok bool) {
+	z.multistream = ok
+}
+
+// NextMember advances past the trailer of the current gzip member and
+// reads the header of the next one, replacing Header with its fields.
+// It is only valid to call NextMember after Read has returned io.EOF at
+// a member boundary, which only happens once Multistream(false) is in
+// effect.
+func (z *Reader) NextMember() error {
+	if !z.atEOF {
+		return errors.New("gzip: NextMember called without a pending member boundary")
+	}
+	z.atEOF = false
+	z.size = 0
 	return z.readHeader(true)
 }
 
@@ -166,6 +212,13 @@ func (z *Reader) readString() (string, error) {
 		}
 		if z.buf[i] == 0 {
 			// GZIP (RFC 1952) specifies that strings are NUL-terminated ISO 8859-1 (Latin-1).
+			if z.digest != nil {
+				// The FHCRC, if present, covers these raw bytes
+				// including the NUL terminator (RFC 1952 §2.3.1).
+				// z.digest is nil when readString is exercised
+				// directly, without a fully-initialized Reader.
+				z.digest.Write(z.buf[0 : i+1])
+			}
 			if needconv {
 				s := make([]rune, 0, i)
 				for _, v := range z.buf[0:i] {
@@ -208,10 +261,14 @@ func (z *Reader) readHeader(save bool) error {
 		if err != nil {
 			return err
 		}
+		// The FHCRC, if present, covers the length prefix too (RFC 1952
+		// §2.3.1), so it must go into the digest before z.buf is reused.
+		z.digest.Write(z.buf[0:2])
 		data := make([]byte, n)
 		if _, err = io.ReadFull(z.r, data); err != nil {
 			return err
 		}
+		z.digest.Write(data)
 		if save {
 			z.Extra = data
 		}
@@ -249,7 +306,9 @@ func (z *Reader) readHeader(save bool) error {
 
 	z.digest.Reset()
 	z.decompressor = flate.NewReader(z.r)
-	z.doReadAhead()
+	// Readahead is started lazily on the first call to Read, so that
+	// SetConcurrency can still select the concurrent decoder right after
+	// the header has been parsed.
 	return nil
 }
 
@@ -270,10 +329,17 @@ func (z *Reader) doReadAhead() {
 	closeErr := make(chan error, 1)
 	z.closeErr = closeErr
 
+	// We hold a local reference to decompressor, since z.decompressor is
+	// reassigned by the next readHeader as soon as this member's Read
+	// loop reports its closing error -- which can race with this
+	// goroutine's own deferred Close call if it reads z.decompressor
+	// instead of the value that was current when it started.
+	decompressor := z.decompressor
+
 	go func() {
 		defer close(z.readAhead)
 		defer func() {
-			closeErr <- z.decompressor.Close()
+			closeErr <- decompressor.Close()
 			close(closeErr)
 		}()
 
@@ -284,7 +350,7 @@ func (z *Reader) doReadAhead() {
 		dLock := sync.Mutex{}
 		for {
 			buf := make([]byte, z.blockSize)
-			n, err := z.decompressor.Read(buf)
+			n, err := decompressor.Read(buf)
 			if n < len(buf) {
 				buf = buf[0:n]
 			}
@@ -321,9 +387,19 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 	if z.err != nil {
 		return 0, z.err
 	}
+	if z.atEOF {
+		return 0, io.EOF
+	}
 	if len(p) == 0 {
 		return 0, nil
 	}
+	if z.closeReader == nil {
+		if z.concurrent {
+			z.doConcurrentReadAhead()
+		} else {
+			z.doReadAhead()
+		}
+	}
 
 	for {
 		if len(z.current) == 0 && !z.lastBlock {
@@ -377,24 +453,38 @@ func (z *Reader) Read(p []byte) (n int, err error) {
 		return 0, z.err
 	}
 
+	if !z.multistream {
+		z.atEOF = true
+		return n, io.EOF
+	}
+
 	// File is ok; is there another?
 	if err = z.readHeader(false); err != nil {
 		z.err = err
 		return
 	}
 
-	// Yes.  Reset and read from it.
+	// Yes.  Reset and read from it, filling whatever space in p is left
+	// after the bytes already copied above from the member that just
+	// ended -- n may be non-zero here, since decompressor.Read can
+	// return its last bytes together with io.EOF in the same call.
 	z.digest.Reset()
 	z.size = 0
-	return z.Read(p)
+	n2, err := z.Read(p[n:])
+	return n + n2, err
 }
 
 // Close closes the Reader. It does not close the underlying io.Reader.
 func (z *Reader) Close() error {
-	if z.closeReader != nil {
-		close(z.closeReader)
-		z.closeReader = nil
+	if z.closeReader == nil {
+		// Readahead is started lazily on the first Read; if Close is
+		// called before any Read (e.g. after only inspecting the
+		// header), z.closeErr was never created and there is nothing
+		// to wait for.
+		return nil
 	}
+	close(z.closeReader)
+	z.closeReader = nil
 
 	// Wait for decompressor to be closed and return error, if any.
 	e, ok := <-z.closeErr
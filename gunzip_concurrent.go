@@ -0,0 +1,126 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+// dictWindow is the maximum DEFLATE back-reference distance. BuildIndex
+// primes every block but the first with the last dictWindow bytes of the
+// one before it, since a back-reference can point across a sync-flush
+// boundary into the previous block.
+const dictWindow = 32 << 10
+
+// SetConcurrency enables the pipelined decoder for streams made up of
+// independent, Z_SYNC_FLUSH-delimited deflate blocks, such as those
+// produced by this package's Writer. blockSize and blocks behave as in
+// NewReaderN.
+//
+// This is a readahead/pipelining improvement only, not a parallel
+// decoder: blocks sync-flushed against each other carry DEFLATE
+// back-references across the boundary, so inflating one requires having
+// already inflated everything before it, and that dependency chain
+// serializes decoding of a single member regardless of blocks. What this
+// path buys over the plain single-stream decoder is a normal Reader, so
+// Read can keep draining already-decoded output from z.readAhead while
+// more is produced, and the checksum bookkeeping for it runs
+// independently of the inflate itself.
+//
+// Earlier versions of this decoder tried to split the compressed stream
+// into per-block frames by scanning for the raw byte sequence a sync
+// flush leaves behind and decoding each frame in isolation. That scan
+// cannot tell a genuine block boundary from a coincidental occurrence of
+// the same 4 bytes inside ordinary Huffman-coded data -- both decode a
+// truncated, isolated frame to io.ErrUnexpectedEOF -- so on a large
+// enough stream it would eventually cut at the wrong place and silently
+// decode the wrong bytes with no error reported. This decoder sidesteps
+// the whole problem by never splitting the input at all: it decodes the
+// member continuously through a single flate.Reader, which has no
+// trouble with interior sync flushes (to it, they're just ordinary empty
+// stored blocks) and only stops where the bitstream actually ends. Block
+// boundaries only matter for indexing a stream for seeking, not for
+// decoding it, and that's what BuildIndex is for.
+//
+// SetConcurrency must be called before the first call to Read. Streams
+// that turn out not to contain any sync-flush boundaries are still
+// decoded correctly, just as a single tail block.
+func (z *Reader) SetConcurrency(blockSize, blocks int) error {
+	if blockSize <= 0 || blocks <= 0 {
+		return errors.New("gzip: invalid SetConcurrency parameters")
+	}
+	if z.closeReader != nil {
+		return errors.New("gzip: SetConcurrency called after Read")
+	}
+	z.blockSize = blockSize
+	z.blocks = blocks
+	z.concurrent = true
+	return nil
+}
+
+// doConcurrentReadAhead decodes the member continuously through a single
+// flate.Reader on a background goroutine, delivering blockSize-sized
+// chunks of decoded output to Read, in order, over z.readAhead exactly
+// like doReadAhead does.
+func (z *Reader) doConcurrentReadAhead() {
+	if z.blocks <= 0 {
+		z.blocks = defaultBlocks
+	}
+	if z.blockSize <= 512 {
+		z.blockSize = defaultBlockSize
+	}
+	z.readAhead = make(chan interface{}, z.blocks*2)
+	closeReader := make(chan struct{}, 0)
+	z.closeReader = closeReader
+	z.lastBlock = false
+	closeErr := make(chan error, 1)
+	z.closeErr = closeErr
+
+	go func() {
+		defer close(z.readAhead)
+		defer func() {
+			closeErr <- nil
+			close(closeErr)
+		}()
+
+		fr := flate.NewReader(z.r)
+		defer fr.Close()
+		for {
+			buf := make([]byte, z.blockSize)
+			n, err := io.ReadFull(fr, buf)
+			buf = buf[:n]
+			if n > 0 {
+				z.size += uint32(n)
+				z.digest.Write(buf)
+				select {
+				case z.readAhead <- buf:
+					z.readAhead <- error(nil)
+				case <-closeReader:
+					return
+				}
+			}
+			if err == io.ErrUnexpectedEOF {
+				err = io.EOF
+			}
+			if err != nil {
+				select {
+				case z.readAhead <- []byte(nil):
+					z.readAhead <- err
+				case <-closeReader:
+				}
+				return
+			}
+		}
+	}()
+}
+
+func dictTail(b []byte) []byte {
+	if len(b) > dictWindow {
+		return b[len(b)-dictWindow:]
+	}
+	return b
+}
@@ -0,0 +1,144 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// buildSyncFlushedGzip builds a single gzip member whose deflate stream
+// contains a Z_SYNC_FLUSH boundary after every chunk but the last, using
+// the stdlib compress/flate encoder directly so the resulting stream
+// exercises the same "final block also ends in the sync marker"
+// ambiguity that a real compress/flate-produced member has.
+func buildSyncFlushedGzip(t *testing.T, chunks [][]byte) []byte {
+	t.Helper()
+
+	var body bytes.Buffer
+	fw, err := flate.NewWriter(&body, flate.DefaultCompression)
+	if err != nil {
+		t.Fatalf("flate.NewWriter: %v", err)
+	}
+	digest := crc32.NewIEEE()
+	var size uint32
+	for i, chunk := range chunks {
+		if _, err := fw.Write(chunk); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if i != len(chunks)-1 {
+			if err := fw.Flush(); err != nil {
+				t.Fatalf("Flush: %v", err)
+			}
+		}
+		digest.Write(chunk)
+		size += uint32(len(chunk))
+	}
+	if err := fw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write([]byte{gzipID1, gzipID2, gzipDeflate, 0, 0, 0, 0, 0, 0, 255})
+	buf.Write(body.Bytes())
+	binary.Write(&buf, binary.LittleEndian, digest.Sum32())
+	binary.Write(&buf, binary.LittleEndian, size)
+	return buf.Bytes()
+}
+
+// TestConcurrentReadAhead checks that the sync-flush-aware decoder
+// enabled by SetConcurrency correctly reassembles an ordinary
+// compress/flate-produced, multi-block member -- including its final
+// block, which ends in the same four-byte signature as every interior
+// sync flush.
+func TestConcurrentReadAhead(t *testing.T) {
+	chunks := [][]byte{
+		bytes.Repeat([]byte("hello "), 1000),
+		bytes.Repeat([]byte("world "), 1000),
+		bytes.Repeat([]byte("again "), 1000),
+	}
+	want := bytes.Join(chunks, nil)
+
+	src := buildSyncFlushedGzip(t, chunks)
+
+	r, err := NewReader(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if err := r.SetConcurrency(1<<16, 4); err != nil {
+		t.Fatalf("SetConcurrency: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %d bytes, want %d bytes; content mismatch", len(got), len(want))
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+// TestCloseWithoutRead checks that closing a Reader that was never read
+// from (e.g. a caller that only wants to inspect the header) returns
+// promptly instead of blocking forever waiting on readahead state that
+// was never started.
+func TestCloseWithoutRead(t *testing.T) {
+	src := buildSyncFlushedGzip(t, [][]byte{[]byte("hello")})
+
+	r, err := NewReader(bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- r.Close() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked waiting on readahead that was never started")
+	}
+}
+
+// TestConcurrentReadAheadMultistream checks that the concurrent decoder
+// stops each member at its true end rather than reading on into the
+// next member's header, by decoding two concatenated sync-flushed
+// members as a single logical stream.
+func TestConcurrentReadAheadMultistream(t *testing.T) {
+	first := buildSyncFlushedGzip(t, [][]byte{
+		[]byte("hello "),
+		[]byte("world"),
+	})
+	second := buildSyncFlushedGzip(t, [][]byte{
+		[]byte("foo"),
+		[]byte("bar\n"),
+	})
+
+	r, err := NewReader(bytes.NewReader(append(append([]byte{}, first...), second...)))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if err := r.SetConcurrency(1<<16, 4); err != nil {
+		t.Fatalf("SetConcurrency: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	want := "hello worldfoobar\n"
+	if string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
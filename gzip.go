@@ -0,0 +1,383 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"compress/flate"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// These constants are copied from the compress/flate package, so that
+// code that imports "github.com/klauspost/pgzip" does not also have to
+// import "compress/flate".
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+	HuffmanOnly        = flate.HuffmanOnly
+)
+
+// defaultBlockSize and defaultBlocks are the block size and
+// concurrency NewWriterLevel and NewReader fall back to when the
+// caller hasn't called SetConcurrency.
+const (
+	defaultBlockSize = 250000
+	defaultBlocks    = 16
+)
+
+// A Writer is an io.WriteCloser that satisfies writes by compressing
+// data written to it and writing it to an underlying writer (w),
+// splitting it into blocks compressed in parallel via DeflateWriter.
+type Writer struct {
+	Header
+	// HdrCRC, if set to true before the first Write, makes Close emit
+	// an FHCRC field covering the whole header, matching what readHeader
+	// validates when the flag is present.
+	HdrCRC bool
+
+	w           io.Writer
+	level       int
+	blockSize   int
+	blocks      int
+	wroteHeader bool
+	closed      bool
+	err         error
+	buf         [10]byte
+	dw          *DeflateWriter
+
+	recordIndex bool
+	index       []IndexEntry
+}
+
+// NewWriter returns a new Writer that compresses data and writes it to
+// w at the default compression level.
+//
+// It is the caller's responsibility to call Close on the Writer when
+// done. Writes may be buffered and not flushed until Close.
+//
+// Callers that wish to set the fields in Writer.Header must do so
+// before the first call to Write, Flush, or Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level
+// instead of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression,
+// HuffmanOnly, or any integer value between BestSpeed and
+// BestCompression inclusive. The error returned is non-nil if level is
+// invalid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > BestCompression {
+		return nil, errors.New("gzip: invalid compression level")
+	}
+	return &Writer{
+		w:         w,
+		level:     level,
+		blockSize: defaultBlockSize,
+		blocks:    defaultBlocks,
+	}, nil
+}
+
+// SetConcurrency adjusts the block size and the number of blocks
+// compressed concurrently. It must be called before the first call to
+// Write, Flush, or Close.
+func (z *Writer) SetConcurrency(blockSize, blocks int) error {
+	if blockSize <= 0 || blocks <= 0 {
+		return errors.New("gzip: invalid SetConcurrency parameters")
+	}
+	if z.wroteHeader {
+		return errors.New("gzip: SetConcurrency called after Write")
+	}
+	z.blockSize = blockSize
+	z.blocks = blocks
+	return nil
+}
+
+// RecordIndex enables accumulating a block index as z writes, so
+// BlockIndex can return it once Close has returned -- the write-time
+// counterpart to reconstructing one after the fact with BuildIndex. It
+// must be called before the first call to Write, Flush, or Close.
+func (z *Writer) RecordIndex() error {
+	if z.wroteHeader {
+		return errors.New("gzip: RecordIndex called after Write")
+	}
+	z.recordIndex = true
+	return nil
+}
+
+// BlockIndex returns the Index accumulated while writing. RecordIndex
+// must have been called beforehand, and the result is only complete
+// once Close has returned.
+func (z *Writer) BlockIndex() *Index {
+	return &Index{entries: z.index}
+}
+
+// writeBytes writes a length-prefixed byte slice to z.w, as used for
+// the FEXTRA field.
+func (z *Writer) writeBytes(b []byte) error {
+	if len(b) > 0xffff {
+		return errors.New("gzip.Write: Extra data is too large")
+	}
+	put2(z.buf[0:2], uint16(len(b)))
+	if _, err := z.w.Write(z.buf[0:2]); err != nil {
+		return err
+	}
+	_, err := z.w.Write(b)
+	return err
+}
+
+// writeString writes a UTF-8 string s in GZIP's NUL-terminated Latin-1
+// encoding to z.w. It returns an error if s contains a NUL or
+// non-Latin-1 rune.
+func (z *Writer) writeString(s string) (err error) {
+	// GZIP (RFC 1952) specifies that strings are NUL-terminated ISO 8859-1 (Latin-1).
+	needconv := false
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return errors.New("gzip.Write: non-Latin-1 header string")
+		}
+		if r > 0x7f {
+			needconv = true
+		}
+	}
+	if needconv {
+		b := make([]byte, 0, len(s))
+		for _, r := range s {
+			b = append(b, byte(r))
+		}
+		_, err = z.w.Write(b)
+	} else {
+		_, err = io.WriteString(z.w, s)
+	}
+	if err != nil {
+		return err
+	}
+	// GZIP strings are NUL-terminated.
+	z.buf[0] = 0
+	_, err = z.w.Write(z.buf[:1])
+	return err
+}
+
+// writeHeader writes the gzip header described by z.Header to z.w,
+// covering it with a trailing FHCRC field if z.HdrCRC is set -- per RFC
+// 1952 §2.3.1 that CRC16 is the low 16 bits of the CRC32 of every
+// preceding header byte, so writing it correctly means tapping every
+// write this function makes, not just the fixed 10-byte prefix.
+func (z *Writer) writeHeader() error {
+	var flg byte
+	if z.Extra != nil {
+		flg |= flagExtra
+	}
+	if z.Name != "" {
+		flg |= flagName
+	}
+	if z.Comment != "" {
+		flg |= flagComment
+	}
+	if z.HdrCRC {
+		flg |= flagHdrCrc
+	}
+
+	z.buf[0] = gzipID1
+	z.buf[1] = gzipID2
+	z.buf[2] = gzipDeflate
+	z.buf[3] = flg
+	put4(z.buf[4:8], uint32(z.ModTime.Unix()))
+	z.buf[8] = 0
+	if z.OS == 0 {
+		z.buf[9] = 255 // unknown
+	} else {
+		z.buf[9] = z.OS
+	}
+
+	origW := z.w
+	var digest hash.Hash32
+	if z.HdrCRC {
+		digest = crc32.NewIEEE()
+		z.w = io.MultiWriter(origW, digest)
+	}
+
+	_, err := z.w.Write(z.buf[0:10])
+	if err == nil && z.Extra != nil {
+		err = z.writeBytes(z.Extra)
+	}
+	if err == nil && z.Name != "" {
+		err = z.writeString(z.Name)
+	}
+	if err == nil && z.Comment != "" {
+		err = z.writeString(z.Comment)
+	}
+
+	z.w = origW
+	if err != nil {
+		return err
+	}
+
+	if z.HdrCRC {
+		put2(z.buf[0:2], uint16(digest.Sum32()&0xffff))
+		if _, err := z.w.Write(z.buf[0:2]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// start writes the header and creates the underlying DeflateWriter on
+// the first call; later calls are a no-op. It is shared by Write,
+// Flush, and Close so each works correctly even if called before any
+// of the others.
+func (z *Writer) start() error {
+	if z.wroteHeader {
+		return z.err
+	}
+	z.wroteHeader = true
+
+	if z.recordIndex {
+		z.w = &countingWriter{w: z.w}
+	}
+	if err := z.writeHeader(); err != nil {
+		z.err = err
+		return err
+	}
+	z.dw = NewDeflateWriter(z.w, z.level, z.blockSize, z.blocks)
+	if z.recordIndex {
+		cw := z.w.(*countingWriter)
+		z.dw.onBlock = func(uncompressedOffset int64, dict []byte) {
+			z.index = append(z.index, IndexEntry{
+				UncompressedOffset: uncompressedOffset,
+				CompressedOffset:   cw.n,
+				Dict:               dict,
+			})
+		}
+	}
+	return nil
+}
+
+// Write writes a compressed form of p to the underlying io.Writer. The
+// compressed bytes are not necessarily flushed until Flush, Close, or
+// enough data has accumulated to fill a block.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if err := z.start(); err != nil {
+		return 0, err
+	}
+	n, err := z.dw.Write(p)
+	if err != nil {
+		z.err = err
+	}
+	return n, err
+}
+
+// Flush flushes any pending compressed data to the underlying writer.
+//
+// It is useful mainly in compressed network protocols, to ensure that
+// a remote reader has enough data to reconstruct a packet. Flush does
+// not return until the data has been written. If the underlying writer
+// returns an error, Flush returns that error.
+//
+// In the terminology of the zlib library, Flush is equivalent to
+// Z_SYNC_FLUSH.
+func (z *Writer) Flush() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	if err := z.start(); err != nil {
+		return err
+	}
+	if err := z.dw.Flush(); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+// UncompressedSize returns the number of bytes written to z so far. It
+// is valid both before and after Close.
+func (z *Writer) UncompressedSize() int {
+	if z.dw == nil {
+		return 0
+	}
+	return z.dw.UncompressedSize()
+}
+
+// Close closes the Writer by flushing any unwritten data to the
+// underlying io.Writer and writing the GZIP footer (CRC32 and ISIZE).
+// It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return nil
+	}
+	z.closed = true
+	if err := z.start(); err != nil {
+		return err
+	}
+	crc, size := z.dw.CRC32(), uint32(z.dw.UncompressedSize())
+	if err := z.dw.Close(); err != nil {
+		z.err = err
+		return z.err
+	}
+	put4(z.buf[0:4], crc)
+	put4(z.buf[4:8], size)
+	if _, err := z.w.Write(z.buf[0:8]); err != nil {
+		z.err = err
+	}
+	return z.err
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the
+// result of NewWriterLevel with the original level, blockSize and
+// blocks, but writing to w instead. This permits reusing a Writer
+// rather than allocating a new one.
+func (z *Writer) Reset(w io.Writer) {
+	z.Header = Header{}
+	z.HdrCRC = false
+	z.w = w
+	z.wroteHeader = false
+	z.closed = false
+	z.err = nil
+	z.dw = nil
+	z.recordIndex = false
+	z.index = nil
+}
+
+func put2(p []byte, v uint16) {
+	p[0] = byte(v)
+	p[1] = byte(v >> 8)
+}
+
+func put4(p []byte, v uint32) {
+	p[0] = byte(v)
+	p[1] = byte(v >> 8)
+	p[2] = byte(v >> 16)
+	p[3] = byte(v >> 24)
+}
+
+// countingWriter wraps an io.Writer, tracking the total number of bytes
+// written through it so Writer can record each block's absolute
+// compressed offset for BlockIndex.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,91 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// TestMultistream checks that, by default, Read transparently
+// concatenates the uncompressed data of several gzip members.
+func TestMultistream(t *testing.T) {
+	var buf bytes.Buffer
+	for _, s := range []string{"hello ", "world"} {
+		w := NewWriter(&buf)
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("got %q, want %q", got, "hello world")
+	}
+}
+
+// TestMultistreamDisabled checks that Multistream(false) stops Read at
+// each member boundary, and that NextMember advances to the next
+// member's Header.
+func TestMultistreamDisabled(t *testing.T) {
+	var buf bytes.Buffer
+	for _, name := range []string{"a.txt", "b.txt"} {
+		w := NewWriter(&buf)
+		w.Name = name
+		if _, err := w.Write([]byte(name)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	r.Multistream(false)
+
+	if r.Name != "a.txt" {
+		t.Fatalf("got Name %q, want %q", r.Name, "a.txt")
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll (member 1): %v", err)
+	}
+	if string(got) != "a.txt" {
+		t.Fatalf("got %q, want %q", got, "a.txt")
+	}
+
+	if err := r.NextMember(); err != nil {
+		t.Fatalf("NextMember: %v", err)
+	}
+	if r.Name != "b.txt" {
+		t.Fatalf("got Name %q, want %q", r.Name, "b.txt")
+	}
+	got, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll (member 2): %v", err)
+	}
+	if string(got) != "b.txt" {
+		t.Fatalf("got %q, want %q", got, "b.txt")
+	}
+
+	if err := r.NextMember(); err != io.EOF {
+		t.Fatalf("NextMember at end of stream: got %v, want io.EOF", err)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bufio"
 	"bytes"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"io/ioutil"
 	"math/rand"
@@ -161,6 +162,81 @@ func TestLatin1RoundTrip(t *testing.T) {
 	}
 }
 
+// TestHeaderCRC verifies that the FHCRC check covers the whole header,
+// not just the fixed 10-byte prefix: a header whose trailing FNAME
+// bytes have been tampered with after the CRC16 was computed must be
+// rejected, even though those 10 bytes are untouched.
+func TestHeaderCRC(t *testing.T) {
+	build := func(name string) []byte {
+		var buf bytes.Buffer
+		buf.Write([]byte{gzipID1, gzipID2, gzipDeflate, flagName | flagHdrCrc, 0, 0, 0, 0, 0, 0xff})
+		buf.WriteString(name)
+		buf.WriteByte(0)
+		digest := crc32.NewIEEE()
+		digest.Write(buf.Bytes())
+		sum := digest.Sum32() & 0xffff
+		buf.WriteByte(byte(sum))
+		buf.WriteByte(byte(sum >> 8))
+		return buf.Bytes()
+	}
+
+	good := build("a")
+	z := Reader{r: bufio.NewReader(bytes.NewReader(good)), digest: crc32.NewIEEE()}
+	if err := z.readHeader(true); err != nil {
+		t.Fatalf("readHeader on a valid FHCRC header: %v", err)
+	}
+	if z.Name != "a" {
+		t.Fatalf("name = %q, want %q", z.Name, "a")
+	}
+
+	tampered := build("a")
+	tampered[10] = 'b' // first byte of the name, past the 10-byte prefix
+	z2 := Reader{r: bufio.NewReader(bytes.NewReader(tampered)), digest: crc32.NewIEEE()}
+	if err := z2.readHeader(true); err != ErrHeader {
+		t.Fatalf("readHeader on a tampered name with stale FHCRC: got %v, want ErrHeader", err)
+	}
+}
+
+// TestWriterHdrCRC checks the write side of FHCRC support TestHeaderCRC
+// doesn't: that setting Writer.HdrCRC actually makes Close emit a header
+// NewReader accepts and reads back correctly, across all three optional
+// header fields.
+func TestWriterHdrCRC(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.HdrCRC = true
+	w.Name = "file.txt"
+	w.Comment = "a comment"
+	w.Extra = []byte("extra data")
+	w.ModTime = time.Unix(1e8, 0)
+
+	want := []byte("hello, world")
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if r.Name != w.Name || r.Comment != w.Comment || !bytes.Equal(r.Extra, w.Extra) {
+		t.Fatalf("header = %+v, want Name %q, Comment %q, Extra %q", r.Header, w.Name, w.Comment, w.Extra)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Reader.Close: %v", err)
+	}
+}
+
 func TestWriterFlush(t *testing.T) {
 	buf := new(bytes.Buffer)
 
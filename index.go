@@ -0,0 +1,264 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pgzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// syncMarker is the signature of the empty stored deflate block that
+// Z_SYNC_FLUSH emits. Writer ends every interior block on one of these,
+// so scanning for it lets BuildIndex split the compressed stream back
+// into the same frames Writer produced. The final block of a member
+// also ends in this exact signature -- compress/flate.Writer.Close
+// always appends an empty stored block to terminate the stream -- the
+// only difference being its BFINAL bit, which a byte-level scan cannot
+// see; that can only be recovered by actually decoding the frame.
+var syncMarker = [4]byte{0x00, 0x00, 0xff, 0xff}
+
+// validateLookahead bounds how many bytes validateBoundary decodes past
+// a candidate marker before deciding it's genuine. It doesn't need to
+// reach the next real boundary or the true end of the member: a
+// misaligned bit position, reinterpreted as a fresh block header,
+// almost always produces an invalid Huffman code within the first
+// handful of bytes.
+const validateLookahead = 1 << 16
+
+// validateBoundary reports whether decoding from a fresh, byte-aligned
+// start at offset off of r, primed with dict, is consistent with a
+// sync-flush boundary actually being there. It reads through an
+// independent io.SectionReader, never touching the forward scan
+// BuildIndex drives through cr, so a candidate that turns out to be
+// spurious costs nothing but a wasted decode: unlike a live, singly
+// consumed stream, r can be read from the same offset as many times as
+// needed.
+//
+// A raw 4-byte match of syncMarker is not, on its own, proof of a real
+// boundary: truncating a valid DEFLATE stream at literally any byte
+// position also produces io.ErrUnexpectedEOF from decoding it in
+// isolation, and the 4-byte sequence occurs by chance inside ordinary
+// Huffman-coded output often enough to matter on large streams. Any
+// outcome of this decode is accepted except a genuine format error,
+// since a format error is what reinterpreting a misaligned bit position
+// as a fresh block header reliably produces.
+func validateBoundary(r io.ReaderAt, off int64, dict []byte) bool {
+	fr := flate.NewReaderDict(io.NewSectionReader(r, off, validateLookahead), dict)
+	_, err := ioutil.ReadAll(fr)
+	fr.Close()
+	return err == nil || err == io.ErrUnexpectedEOF || err == io.EOF
+}
+
+// IndexEntry records where one sync-flush-delimited block of a pgzip
+// stream begins, both in the uncompressed data it produces and in the
+// underlying compressed stream, along with the preset dictionary needed
+// to start decoding it on its own.
+type IndexEntry struct {
+	UncompressedOffset int64
+	CompressedOffset   int64
+	Dict               []byte
+}
+
+// Index is a lookup table of block boundaries for a pgzip-produced
+// gzip member. It allows seeking to an arbitrary uncompressed offset
+// without decoding everything before it. Obtain one either by calling
+// Writer.RecordIndex before writing and Writer.BlockIndex after Close,
+// or by reconstructing one from an already-written file with
+// BuildIndex.
+type Index struct {
+	entries []IndexEntry
+}
+
+// find returns the entry covering uncompressed offset off: the last
+// entry whose UncompressedOffset is <= off. It reports an error for an
+// off before the start of the indexed data, including any negative
+// off, or for an empty Index.
+func (idx *Index) find(off int64) (IndexEntry, error) {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return idx.entries[i].UncompressedOffset > off
+	})
+	if i == 0 {
+		return IndexEntry{}, errors.New("gzip: offset out of range")
+	}
+	return idx.entries[i-1], nil
+}
+
+// countingReader wraps a flate.Reader, tracking how many logical bytes
+// have been read through it so callers can recover absolute offsets
+// into the original stream even though z.r may itself be buffered.
+type countingReader struct {
+	r   flate.Reader
+	pos *int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.pos += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		*c.pos++
+	}
+	return b, err
+}
+
+// BuildIndex reconstructs an Index for an existing pgzip-produced gzip
+// member by decoding it once and recording a boundary at every
+// confirmed Z_SYNC_FLUSH marker it finds (see validateBoundary). It
+// only indexes the first member of r; concatenated streams should be
+// split by the caller first.
+func BuildIndex(r io.ReaderAt) (*Index, error) {
+	var pos int64
+	cr := &countingReader{r: makeReader(io.NewSectionReader(r, 0, 1<<62)), pos: &pos}
+
+	z := new(Reader)
+	z.r = cr
+	z.digest = crc32.NewIEEE()
+	if err := z.readHeader(false); err != nil {
+		return nil, err
+	}
+
+	idx := &Index{}
+	var uncompressed int64
+	var dict []byte
+	frame := make([]byte, 0, defaultBlockSize)
+	compressedStart := pos
+
+	for {
+		idx.entries = append(idx.entries, IndexEntry{
+			UncompressedOffset: uncompressed,
+			CompressedOffset:   compressedStart,
+			Dict:               dict,
+		})
+
+		for {
+			b, rerr := cr.ReadByte()
+			if rerr != nil {
+				if rerr != io.EOF {
+					return nil, rerr
+				}
+				// Ran out of input without a confirmed boundary: the
+				// rest of the member has to be the final block.
+				fr := flate.NewReaderDict(bytes.NewReader(frame), dict)
+				out, derr := ioutil.ReadAll(fr)
+				fr.Close()
+				if derr != nil {
+					return nil, derr
+				}
+				uncompressed += int64(len(out))
+				return idx, nil
+			}
+			frame = append(frame, b)
+			n := len(frame)
+			if n < 4 || frame[n-4] != syncMarker[0] || frame[n-3] != syncMarker[1] ||
+				frame[n-2] != syncMarker[2] || frame[n-1] != syncMarker[3] {
+				continue
+			}
+
+			fr := flate.NewReaderDict(bytes.NewReader(frame), dict)
+			out, derr := ioutil.ReadAll(fr)
+			fr.Close()
+			if derr != nil && derr != io.ErrUnexpectedEOF {
+				return nil, derr
+			}
+			if derr == nil {
+				// The tail block of a member doesn't end on a sync
+				// marker (it's BFINAL-terminated instead), so a frame
+				// that decodes cleanly to true EOF means the member is
+				// fully indexed.
+				uncompressed += int64(len(out))
+				return idx, nil
+			}
+			if !validateBoundary(r, pos, dictTail(out)) {
+				// Spurious match: the marker bytes occurred by chance
+				// inside ordinary compressed data, not on a real
+				// boundary. Keep scanning from here for a genuine one.
+				continue
+			}
+
+			uncompressed += int64(len(out))
+			compressedStart = pos
+			dict = dictTail(out)
+			frame = frame[:0]
+			break
+		}
+	}
+}
+
+// IndexedReader provides random access into a pgzip-produced gzip
+// member using an Index built by BuildIndex, without requiring the
+// caller to decode everything before the requested offset. It
+// implements both io.ReaderAt and io.ReadSeeker.
+type IndexedReader struct {
+	ra  io.ReaderAt
+	idx *Index
+	pos int64
+}
+
+// NewIndexedReader returns an IndexedReader that serves reads against
+// ra using idx to locate the block containing any given offset.
+func NewIndexedReader(ra io.ReaderAt, idx *Index) *IndexedReader {
+	return &IndexedReader{ra: ra, idx: idx}
+}
+
+// Seek implements io.Seeker. io.SeekEnd is not supported, since the
+// Index does not record the total uncompressed size.
+func (ir *IndexedReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		ir.pos = offset
+	case io.SeekCurrent:
+		ir.pos += offset
+	default:
+		return 0, errors.New("gzip: IndexedReader.Seek: SeekEnd is not supported")
+	}
+	if ir.pos < 0 {
+		return 0, errors.New("gzip: negative seek position")
+	}
+	return ir.pos, nil
+}
+
+// Read implements io.Reader, continuing from the position last set by
+// Seek (or the start of the stream, initially).
+func (ir *IndexedReader) Read(p []byte) (int, error) {
+	n, err := ir.ReadAt(p, ir.pos)
+	ir.pos += int64(n)
+	return n, err
+}
+
+// ReadAt implements io.ReaderAt, decoding only the block that contains
+// off (plus, if p is larger than one block, however many blocks follow
+// it) rather than the whole member.
+func (ir *IndexedReader) ReadAt(p []byte, off int64) (int, error) {
+	e, err := ir.idx.find(off)
+	if err != nil {
+		return 0, err
+	}
+	fr := flate.NewReaderDict(io.NewSectionReader(ir.ra, e.CompressedOffset, 1<<62), e.Dict)
+	defer fr.Close()
+	if skip := off - e.UncompressedOffset; skip > 0 {
+		if _, err := io.CopyN(ioutil.Discard, fr, skip); err != nil {
+			return 0, err
+		}
+	}
+	n, err := io.ReadFull(fr, p)
+	if err == io.ErrUnexpectedEOF {
+		// io.ReadFull reports a short read against the member's end as
+		// ErrUnexpectedEOF, but per the io.ReaderAt contract a short
+		// read at the end of the underlying data is not an error
+		// condition; report it the same way a plain io.Reader would,
+		// as io.EOF.
+		err = io.EOF
+	}
+	return n, err
+}